@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Route maps a set of alert label matchers to a Sentry DSN. An alert must
+// have a matching value for every label in Match to be routed here.
+//
+// SentryAPI, if set, overrides the top-level Config.SentryAPI for alerts
+// matching this route, so CloseIssue looks up and resolves the issue in the
+// same tenant project the alert was actually sent to.
+type Route struct {
+	Match     map[string]string `yaml:"match"`
+	DSN       string            `yaml:"dsn"`
+	SentryAPI *SentryAPIConfig  `yaml:"sentry_api"`
+}
+
+// Config is the top-level structure of the --config YAML file. It lets a
+// single gateway fan alerts out to many Sentry projects based on label
+// selectors, falling back to DefaultDSN when nothing matches.
+type Config struct {
+	DefaultDSN string  `yaml:"default_dsn"`
+	Routes     []Route `yaml:"routes"`
+
+	// TagAnnotations lists annotation keys that should be promoted to
+	// Sentry tags instead of the default "annotations" context.
+	TagAnnotations []string `yaml:"tag_annotations"`
+
+	// Sinks lists additional notification destinations (Slack, Discord,
+	// DingTalk, Feishu, WeCom, or a generic webhook) alongside Sentry.
+	Sinks []SinkConfig `yaml:"sinks"`
+
+	// SentryAPI, if set, lets the gateway close the matching Sentry issue
+	// via the Sentry Web API when a resolve arrives for a known fingerprint.
+	// It's the default used when no route (or a matching route with its own
+	// SentryAPI unset) applies; see Route.SentryAPI for per-tenant overrides.
+	SentryAPI *SentryAPIConfig `yaml:"sentry_api"`
+}
+
+// SentryAPIConfig holds credentials for the Sentry Web API (distinct from
+// the ingestion DSN), used only for closing resolved issues.
+type SentryAPIConfig struct {
+	BaseURL   string `yaml:"base_url"` // e.g. https://sentry.io/api/0
+	AuthToken string `yaml:"auth_token"`
+	Org       string `yaml:"org"`
+	Project   string `yaml:"project"`
+}
+
+// SinkConfig configures one additional notification sink.
+type SinkConfig struct {
+	Type     string            `yaml:"type"` // slack, discord, dingtalk, feishu, wecom, webhook
+	Name     string            `yaml:"name"`
+	URL      string            `yaml:"url"`
+	Template string            `yaml:"template"` // path to a per-sink template file; falls back to the gateway's --template
+	Match    map[string]string `yaml:"match"`
+}
+
+// loadConfig reads and parses the routing config file at path.
+func loadConfig(path string) (*Config, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(file, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// dsnFor returns the DSN that alerts carrying labels should be routed to,
+// evaluating routes in order and falling back to DefaultDSN.
+func (c *Config) dsnFor(labels map[string]string) string {
+	for _, route := range c.Routes {
+		if route.matches(labels) {
+			return route.DSN
+		}
+	}
+
+	return c.DefaultDSN
+}
+
+// sentryAPIFor returns the Sentry Web API credentials that alerts carrying
+// labels should use to close issues, evaluating routes in order (mirroring
+// dsnFor) and falling back to the top-level SentryAPI. Returns nil if
+// neither a matching route nor the top level configured one.
+func (c *Config) sentryAPIFor(labels map[string]string) *SentryAPIConfig {
+	for _, route := range c.Routes {
+		if route.matches(labels) {
+			if route.SentryAPI != nil {
+				return route.SentryAPI
+			}
+			return c.SentryAPI
+		}
+	}
+
+	return c.SentryAPI
+}
+
+// matches reports whether labels satisfy every selector in the route.
+func (r Route) matches(labels map[string]string) bool {
+	return labelsMatch(r.Match, labels)
+}
+
+// labelsMatch reports whether labels has a matching value for every key in
+// selector. An empty or nil selector matches everything.
+func labelsMatch(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestLabelsMatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		selector map[string]string
+		labels   map[string]string
+		want     bool
+	}{
+		{"nil selector matches everything", nil, map[string]string{"a": "1"}, true},
+		{"exact match", map[string]string{"a": "1"}, map[string]string{"a": "1", "b": "2"}, true},
+		{"missing label", map[string]string{"a": "1"}, map[string]string{"b": "2"}, false},
+		{"mismatched value", map[string]string{"a": "1"}, map[string]string{"a": "2"}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := labelsMatch(c.selector, c.labels); got != c.want {
+				t.Errorf("labelsMatch(%v, %v) = %v, want %v", c.selector, c.labels, got, c.want)
+			}
+		})
+	}
+}
+
+func TestConfigDsnFor(t *testing.T) {
+	cfg := &Config{
+		DefaultDSN: "default-dsn",
+		Routes: []Route{
+			{Match: map[string]string{"tenant": "a"}, DSN: "dsn-a"},
+			{Match: map[string]string{"tenant": "b"}, DSN: "dsn-b"},
+		},
+	}
+
+	if got := cfg.dsnFor(map[string]string{"tenant": "a"}); got != "dsn-a" {
+		t.Errorf("dsnFor(tenant=a) = %q, want dsn-a", got)
+	}
+	if got := cfg.dsnFor(map[string]string{"tenant": "c"}); got != "default-dsn" {
+		t.Errorf("dsnFor(tenant=c) = %q, want default-dsn", got)
+	}
+}
+
+func TestConfigSentryAPIFor(t *testing.T) {
+	defaultAPI := &SentryAPIConfig{Org: "default-org"}
+	tenantAAPI := &SentryAPIConfig{Org: "tenant-a-org"}
+
+	cfg := &Config{
+		SentryAPI: defaultAPI,
+		Routes: []Route{
+			{Match: map[string]string{"tenant": "a"}, DSN: "dsn-a", SentryAPI: tenantAAPI},
+			{Match: map[string]string{"tenant": "b"}, DSN: "dsn-b"},
+		},
+	}
+
+	if got := cfg.sentryAPIFor(map[string]string{"tenant": "a"}); got != tenantAAPI {
+		t.Errorf("sentryAPIFor(tenant=a) = %v, want the route's own SentryAPI", got)
+	}
+	if got := cfg.sentryAPIFor(map[string]string{"tenant": "b"}); got != defaultAPI {
+		t.Errorf("sentryAPIFor(tenant=b) = %v, want the top-level default", got)
+	}
+	if got := cfg.sentryAPIFor(map[string]string{"tenant": "c"}); got != defaultAPI {
+		t.Errorf("sentryAPIFor(tenant=c) = %v, want the top-level default", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupMaxEntries bounds the dedup cache's memory footprint regardless of
+// the configured TTL; entries beyond this are evicted LRU-style.
+const dedupMaxEntries = 100000
+
+// alertFingerprint derives a stable dedup key from an alert's full label
+// set, independent of the narrower Fingerprint used for Sentry grouping.
+func alertFingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(labels[k])
+		sb.WriteByte(',')
+	}
+
+	return sb.String()
+}
+
+type dedupEntry struct {
+	fingerprint string
+	expiry      time.Time
+}
+
+// dedupCache is a fingerprint-keyed LRU with a TTL, used to suppress
+// re-sending an identical firing alert within a configured window.
+type dedupCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	order *list.List
+	elems map[string]*list.Element
+}
+
+func newDedupCache(ttl time.Duration) *dedupCache {
+	return &dedupCache{
+		ttl:   ttl,
+		order: list.New(),
+		elems: make(map[string]*list.Element),
+	}
+}
+
+// seen reports whether fingerprint was already recorded within the TTL
+// window. It does not itself record anything: callers must call mark once
+// the alert has actually been delivered, otherwise an alert that fails
+// every sink would be treated as a duplicate on retry and dropped forever.
+func (d *dedupCache) seen(fingerprint string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	el, ok := d.elems[fingerprint]
+	if !ok {
+		return false
+	}
+
+	entry := el.Value.(*dedupEntry)
+	if time.Now().After(entry.expiry) {
+		d.order.Remove(el)
+		delete(d.elems, fingerprint)
+		return false
+	}
+
+	d.order.MoveToFront(el)
+	return true
+}
+
+// mark records fingerprint as delivered, so it is treated as a duplicate by
+// seen until the TTL elapses. Call this only after a successful send.
+func (d *dedupCache) mark(fingerprint string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	if el, ok := d.elems[fingerprint]; ok {
+		el.Value.(*dedupEntry).expiry = now.Add(d.ttl)
+		d.order.MoveToFront(el)
+		return
+	}
+
+	el := d.order.PushFront(&dedupEntry{fingerprint: fingerprint, expiry: now.Add(d.ttl)})
+	d.elems[fingerprint] = el
+
+	for d.order.Len() > dedupMaxEntries {
+		back := d.order.Back()
+		if back == nil {
+			break
+		}
+		d.order.Remove(back)
+		delete(d.elems, back.Value.(*dedupEntry).fingerprint)
+	}
+}
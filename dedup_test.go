@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	amt "github.com/prometheus/alertmanager/template"
+)
+
+func TestDedupCacheSeenBeforeMark(t *testing.T) {
+	d := newDedupCache(time.Hour)
+
+	if d.seen("fp") {
+		t.Fatal("seen reported true before any mark")
+	}
+}
+
+func TestDedupCacheMarkThenSeen(t *testing.T) {
+	d := newDedupCache(time.Hour)
+
+	d.mark("fp")
+	if !d.seen("fp") {
+		t.Fatal("seen reported false right after mark")
+	}
+}
+
+func TestDedupCacheExpiry(t *testing.T) {
+	d := newDedupCache(10 * time.Millisecond)
+
+	d.mark("fp")
+	time.Sleep(20 * time.Millisecond)
+
+	if d.seen("fp") {
+		t.Fatal("seen reported true after TTL expired")
+	}
+}
+
+// TestDedupCacheFailedSendIsNotSuppressed reproduces the scenario this cache
+// exists to avoid: a fingerprint must not be treated as a duplicate until a
+// send for it has actually succeeded, otherwise the spool's retry of a
+// failed alert would be silently dropped.
+func TestDedupCacheFailedSendIsNotSuppressed(t *testing.T) {
+	d := newDedupCache(time.Hour)
+
+	if d.seen("fp") {
+		t.Fatal("seen reported true for a fingerprint that was never marked")
+	}
+	// A failed send must not call mark, so a retry is still not "seen".
+	if d.seen("fp") {
+		t.Fatal("seen reported true after a failed send with no mark")
+	}
+}
+
+// TestDispatcherResolvedDoesNotExtendDedupWindow reproduces the flapping
+// bug: sending a resolved notification must not re-mark (and so extend) the
+// firing dedup window, or a genuine re-fire shortly after the window would
+// have naturally expired gets silently swallowed as a "duplicate" forever.
+func TestDispatcherResolvedDoesNotExtendDedupWindow(t *testing.T) {
+	sink := &fakeSink{name: "sink"}
+	tmpl := testTemplate(t)
+	ttl := 40 * time.Millisecond
+	disp := newDispatcher([]routedSink{{sink: sink, tmpl: tmpl}}, ttl, false)
+
+	labels := map[string]string{"alertname": "TestAlert"}
+
+	firing := amt.Alert{Labels: labels, Status: "firing"}
+	disp.processWebhook(newTestWebhook(firing), nil)
+
+	// Resolve partway through the firing alert's dedup window. With the bug,
+	// this call's mark() would push the window's expiry out by another ttl.
+	time.Sleep(ttl / 2)
+	resolved := amt.Alert{Labels: labels, Status: "resolved"}
+	disp.processWebhook(newTestWebhook(resolved), nil)
+
+	// Wait past the *original* firing window. A correctly-behaving cache
+	// lets the re-fire through now; the bug would still be suppressing it.
+	time.Sleep(ttl)
+	refire := amt.Alert{Labels: labels, Status: "firing"}
+	disp.processWebhook(newTestWebhook(refire), nil)
+
+	if len(sink.sent) != 3 {
+		t.Fatalf("expected firing, resolved, and re-fire to all reach the sink, got %d sends: %v", len(sink.sent), sink.sent)
+	}
+}
+
+// TestDispatcherSuppressesDuplicateFiring is the companion happy path: two
+// identical firing alerts within the dedup window should only be sent once.
+func TestDispatcherSuppressesDuplicateFiring(t *testing.T) {
+	sink := &fakeSink{name: "sink"}
+	tmpl := testTemplate(t)
+	disp := newDispatcher([]routedSink{{sink: sink, tmpl: tmpl}}, time.Hour, false)
+
+	alert := amt.Alert{Labels: map[string]string{"alertname": "TestAlert"}, Status: "firing"}
+
+	disp.processWebhook(newTestWebhook(alert), nil)
+	disp.processWebhook(newTestWebhook(alert), nil)
+
+	if len(sink.sent) != 1 {
+		t.Fatalf("expected duplicate firing alert to be suppressed, sink saw %d sends", len(sink.sent))
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	amt "github.com/prometheus/alertmanager/template"
+)
+
+// eventSink is implemented by sinks that want their template executed
+// against a richer, sink-specific data structure (e.g. sentrySink exposes
+// the in-progress sentry.Event) rather than the plain alert.
+type eventSink interface {
+	SendEvent(wh *notify.WebhookMessage, alert amt.Alert, tmpl *template.Template) error
+}
+
+// issueCloser is implemented by sinks that can resolve an upstream issue
+// when an alert resolves, keyed by the same fingerprint used for dedup.
+// labels is the resolved alert's label set, so a sink that routes per-tenant
+// (e.g. sentrySink) can resolve the issue in the right destination.
+type issueCloser interface {
+	CloseIssue(fingerprint string, labels map[string]string) error
+}
+
+// Dispatcher fans each alert in a webhook out to its matching sinks, while
+// suppressing duplicate firing alerts within the dedup window and giving
+// resolved alerts special handling.
+type Dispatcher struct {
+	sinks        []routedSink
+	dedup        *dedupCache
+	skipResolved bool
+}
+
+// newDispatcher builds a Dispatcher. A zero dedupWindow disables dedup.
+func newDispatcher(sinks []routedSink, dedupWindow time.Duration, skipResolved bool) *Dispatcher {
+	var dedup *dedupCache
+	if dedupWindow > 0 {
+		dedup = newDedupCache(dedupWindow)
+	}
+
+	return &Dispatcher{sinks: sinks, dedup: dedup, skipResolved: skipResolved}
+}
+
+func (d *Dispatcher) run(hookChan chan *notify.WebhookMessage) {
+	for wh := range hookChan {
+		hookChanDepth.Set(float64(len(hookChan)))
+
+		if failed := d.processWebhook(wh, nil); len(failed) > 0 {
+			fmt.Fprintf(os.Stderr, "Failed to send %d/%d alert(s)\n", len(failed), len(wh.Alerts))
+		}
+	}
+}
+
+// dispatchFailure describes one alert that still has sinks owed a delivery.
+type dispatchFailure struct {
+	Alert        amt.Alert
+	PendingSinks []string
+}
+
+// processWebhook dispatches every alert in wh to each routed sink whose
+// label selector matches it, returning the alerts that still have at least
+// one sink pending along with exactly which sinks those are. pending
+// restricts, per alert fingerprint, which sinks are attempted this time —
+// nil means "every matching sink" (a fresh webhook); a caller retrying a
+// prior partial failure (the spool sender) passes back the PendingSinks it
+// was given, so sinks that already succeeded are never re-delivered to.
+func (d *Dispatcher) processWebhook(wh *notify.WebhookMessage, pending map[string][]string) []dispatchFailure {
+	var failed []dispatchFailure
+
+	for _, alert := range wh.Alerts {
+		alertsProcessed.Inc()
+
+		fingerprint := alertFingerprint(alert.Labels)
+
+		if alert.Status == "resolved" {
+			resolvedTotal.Inc()
+			d.closeIssues(fingerprint, alert.Labels)
+			if d.skipResolved {
+				continue
+			}
+		} else if d.dedup != nil && d.dedup.seen(fingerprint) {
+			dedupSuppressed.Inc()
+			continue
+		}
+
+		var stillPending []string
+		for _, rs := range d.sinks {
+			if !rs.matches(alert.Labels) {
+				continue
+			}
+			if pending != nil && !sinkNameIn(pending[fingerprint], rs.sink.Name()) {
+				continue
+			}
+
+			err := dispatchToSink(wh, alert, rs)
+
+			outcome := "success"
+			if err != nil {
+				outcome = "failure"
+				stillPending = append(stillPending, rs.sink.Name())
+				fmt.Fprintf(os.Stderr, "%s send failed: %s\n", rs.sink.Name(), err)
+			}
+			sinkSendsTotal.WithLabelValues(rs.sink.Name(), outcome).Inc()
+		}
+
+		if len(stillPending) > 0 {
+			failed = append(failed, dispatchFailure{Alert: alert, PendingSinks: stillPending})
+		} else if alert.Status != "resolved" && d.dedup != nil {
+			// Only the firing path gates the dedup window. Marking on a
+			// resolved send too would let a genuine re-fire shortly after
+			// resolution get suppressed as a "duplicate" of the resolve.
+			d.dedup.mark(fingerprint)
+		}
+	}
+
+	return failed
+}
+
+func sinkNameIn(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// closeIssues asks every sink that supports it to resolve its upstream issue
+// for fingerprint. Failures are logged, not propagated, since this is a
+// best-effort extra on top of sending the resolved notification itself.
+func (d *Dispatcher) closeIssues(fingerprint string, labels map[string]string) {
+	for _, rs := range d.sinks {
+		closer, ok := rs.sink.(issueCloser)
+		if !ok {
+			continue
+		}
+
+		if err := closer.CloseIssue(fingerprint, labels); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close %s issue: %s\n", rs.sink.Name(), err)
+		}
+	}
+}
+
+func dispatchToSink(wh *notify.WebhookMessage, alert amt.Alert, rs routedSink) error {
+	if es, ok := rs.sink.(eventSink); ok {
+		return es.SendEvent(wh, alert, rs.tmpl)
+	}
+
+	var buf bytes.Buffer
+	var rendered string
+	if err := rs.tmpl.Execute(&buf, alert); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid template: %s\n", err)
+		templateRenderFailures.Inc()
+		if alert.Labels["alertname"] == "" {
+			rendered = "fallback"
+		} else {
+			rendered = alert.Labels["alertname"]
+		}
+	} else {
+		rendered = buf.String()
+	}
+
+	return rs.sink.Send(context.Background(), alert, rendered)
+}
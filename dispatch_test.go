@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify"
+	amt "github.com/prometheus/alertmanager/template"
+)
+
+// fakeSink records every alert it's asked to send and can be made to fail
+// for specific alertnames, to simulate one flaky sink among several.
+type fakeSink struct {
+	name string
+	fail map[string]bool
+	sent []string
+}
+
+func (f *fakeSink) Name() string { return f.name }
+
+func (f *fakeSink) Send(ctx context.Context, alert amt.Alert, rendered string) error {
+	alertname := alert.Labels["alertname"]
+	f.sent = append(f.sent, alertname)
+	if f.fail[alertname] {
+		return fmt.Errorf("%s: simulated failure for %s", f.name, alertname)
+	}
+	return nil
+}
+
+func testTemplate(t *testing.T) *template.Template {
+	t.Helper()
+	tmpl, err := template.New("").Parse("{{ .Labels.alertname }}")
+	if err != nil {
+		t.Fatalf("parsing test template: %s", err)
+	}
+	return tmpl
+}
+
+func newTestWebhook(alert amt.Alert) *notify.WebhookMessage {
+	return &notify.WebhookMessage{Data: &amt.Data{Alerts: amt.Alerts{alert}}}
+}
+
+// TestDispatcherRetriesOnlyFailedSink reproduces the duplicate-delivery bug:
+// an alert matching two sinks where only one fails must not be re-sent to
+// the sink that already succeeded on retry.
+func TestDispatcherRetriesOnlyFailedSink(t *testing.T) {
+	ok := &fakeSink{name: "ok"}
+	flaky := &fakeSink{name: "flaky", fail: map[string]bool{"TestAlert": true}}
+
+	tmpl := testTemplate(t)
+	sinks := []routedSink{
+		{sink: ok, tmpl: tmpl},
+		{sink: flaky, tmpl: tmpl},
+	}
+	disp := newDispatcher(sinks, 0, false)
+
+	alert := amt.Alert{Labels: map[string]string{"alertname": "TestAlert"}, Status: "firing"}
+	wh := newTestWebhook(alert)
+
+	failed := disp.processWebhook(wh, nil)
+	if len(failed) != 1 {
+		t.Fatalf("expected 1 failed alert, got %d", len(failed))
+	}
+	if got := failed[0].PendingSinks; len(got) != 1 || got[0] != "flaky" {
+		t.Fatalf("expected only %q pending, got %v", "flaky", got)
+	}
+
+	// Retry using the pending sinks the first pass returned.
+	pending := map[string][]string{alertFingerprint(alert.Labels): failed[0].PendingSinks}
+	flaky.fail = nil // the sink recovers
+	retryFailed := disp.processWebhook(wh, pending)
+	if len(retryFailed) != 0 {
+		t.Fatalf("expected retry to succeed, got %d still failed", len(retryFailed))
+	}
+
+	if len(ok.sent) != 1 {
+		t.Fatalf("sink that already succeeded was re-delivered to: sent %d times", len(ok.sent))
+	}
+	if len(flaky.sent) != 2 {
+		t.Fatalf("expected flaky sink to be attempted twice (fail, then retry), got %d", len(flaky.sent))
+	}
+}
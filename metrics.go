@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	webhooksReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sentry_gateway_webhooks_received_total",
+		Help: "Total number of Alertmanager webhook requests received.",
+	})
+
+	alertsProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sentry_gateway_alerts_processed_total",
+		Help: "Total number of individual alerts processed from received webhooks.",
+	})
+
+	templateRenderFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sentry_gateway_template_render_failures_total",
+		Help: "Total number of alerts whose message template failed to render.",
+	})
+
+	sentrySendDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sentry_gateway_sentry_send_duration_seconds",
+		Help:    "Time taken for a Sentry event capture to report a send outcome.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	hookChanDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_gateway_hook_chan_depth",
+		Help: "Current number of webhook payloads queued for processing.",
+	})
+
+	spoolEvicted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sentry_gateway_spool_evicted_total",
+		Help: "Total number of spooled webhook files evicted for exceeding the spool quota.",
+	})
+
+	sinkSendsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_gateway_sink_sends_total",
+		Help: "Total number of notification sink send attempts, partitioned by sink name and outcome.",
+	}, []string{"sink", "outcome"})
+
+	dedupSuppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sentry_gateway_dedup_suppressed_total",
+		Help: "Total number of firing alerts suppressed as duplicates within the dedup window.",
+	})
+
+	resolvedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sentry_gateway_resolved_total",
+		Help: "Total number of resolved alerts received.",
+	})
+
+	sentryIssuesClosedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_gateway_sentry_issues_closed_total",
+		Help: "Total number of attempts to close a Sentry issue on alert resolve, partitioned by outcome.",
+	}, []string{"outcome"})
+)
+
+// metricsMux returns a mux serving the Prometheus /metrics handler.
+func metricsMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
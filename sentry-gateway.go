@@ -1,23 +1,23 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/getsentry/raven-go"
 	"github.com/prometheus/alertmanager/notify"
 	amt "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 )
 
@@ -28,6 +28,10 @@ var (
 
 const (
 	defaultTemplate = "{{ .Labels.alertname }} - {{ .Labels.namespace }}/{{ .Labels.pod_name}}\n{{ .Annotations.message }}"
+
+	// maxRequestSize bounds the size of an incoming webhook body to guard
+	// against unbounded memory use from a malicious or misconfigured sender.
+	maxRequestSize = 1 << 20 // 1 MiB
 )
 
 func main() {
@@ -37,9 +41,16 @@ func main() {
 		RunE:  run,
 	}
 
-	cmd.Flags().StringP("dsn", "d", "", "Sentry DSN")
+	cmd.Flags().StringP("dsn", "d", "", "Sentry DSN (default, used when no route matches and --config sets no default_dsn, or when --config is unset)")
+	cmd.Flags().StringP("config", "c", "", "Path of the YAML config file mapping label selectors to Sentry DSNs")
 	cmd.Flags().StringP("template", "t", "", "Path of the template file of event message")
 	cmd.Flags().StringP("addr", "a", "0.0.0.0:9096", "Address to listen on for WebHook")
+	cmd.Flags().String("metrics-addr", "", "Address to serve Prometheus /metrics on (defaults to serving it on --addr)")
+	cmd.Flags().String("spool-dir", "", "Directory to spool webhooks to before sending, for durability across Sentry outages and restarts (disabled if empty)")
+	cmd.Flags().Int("max-spool-files", 10000, "Maximum number of webhook files to retain in --spool-dir")
+	cmd.Flags().Int("max-spool-size-mb", 512, "Maximum total size in MB of --spool-dir")
+	cmd.Flags().Duration("dedup-window", time.Hour, "Suppress duplicate firing alerts sharing a fingerprint within this window (0 disables dedup)")
+	cmd.Flags().Bool("skip-resolved", false, "Skip sending notifications for resolved alerts entirely (default is to notify with Level=Info and a resolved tag)")
 	cmd.Flags().Bool("version", false, "Display version information and exit")
 
 	cmd.SilenceUsage = true
@@ -68,6 +79,11 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return err
+	}
+
 	tmplPath, err := cmd.Flags().GetString("template")
 	if err != nil {
 		return err
@@ -78,10 +94,57 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if dsn == "" {
-		return errors.New("Sentry DSN required")
+	metricsAddr, err := cmd.Flags().GetString("metrics-addr")
+	if err != nil {
+		return err
+	}
+
+	spoolDir, err := cmd.Flags().GetString("spool-dir")
+	if err != nil {
+		return err
+	}
+
+	maxSpoolFiles, err := cmd.Flags().GetInt("max-spool-files")
+	if err != nil {
+		return err
+	}
+
+	maxSpoolSizeMB, err := cmd.Flags().GetInt("max-spool-size-mb")
+	if err != nil {
+		return err
+	}
+
+	dedupWindow, err := cmd.Flags().GetDuration("dedup-window")
+	if err != nil {
+		return err
+	}
+
+	skipResolved, err := cmd.Flags().GetBool("skip-resolved")
+	if err != nil {
+		return err
+	}
+
+	var cfg *Config
+	if configPath != "" {
+		cfg, err = loadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if cfg.DefaultDSN == "" {
+			cfg.DefaultDSN = dsn
+		}
+	} else {
+		cfg = &Config{DefaultDSN: dsn}
+	}
+
+	if cfg.DefaultDSN == "" {
+		return errors.New("Sentry DSN required: set --dsn or default_dsn in --config")
+	}
+
+	hubs, err := buildHubPool(cfg)
+	if err != nil {
+		return err
 	}
-	raven.SetDSN(dsn)
 
 	tmpl := defaultTemplate
 	if tmplPath != "" {
@@ -100,10 +163,39 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	ss := newSentrySink(cfg, hubs)
+	sinks, err := buildRoutedSinks(cfg, t, routedSink{sink: ss, tmpl: t})
+	if err != nil {
+		return err
+	}
+
+	disp := newDispatcher(sinks, dedupWindow, skipResolved)
+
+	var spool *Spool
+	if spoolDir != "" {
+		spool, err = NewSpool(spoolDir, maxSpoolFiles, maxSpoolSizeMB)
+		if err != nil {
+			return err
+		}
+	}
+
 	hookChan := make(chan *notify.WebhookMessage)
 
+	var shuttingDown int32
+	var inFlight sync.WaitGroup
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&shuttingDown) == 1 {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		inFlight.Add(1)
+		defer inFlight.Done()
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
 		var wh notify.WebhookMessage
 
 		decoder := json.NewDecoder(r.Body)
@@ -115,9 +207,33 @@ func run(cmd *cobra.Command, args []string) error {
 			return
 		}
 
+		webhooksReceived.Inc()
+
+		if spool != nil {
+			if err := spool.Enqueue(&wh); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to spool webhook: %s\n", err)
+				http.Error(w, "failed to spool webhook", http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+
 		hookChan <- &wh
+		hookChanDepth.Set(float64(len(hookChan)))
 	})
 
+	if metricsAddr == "" {
+		mux.Handle("/metrics", promhttp.Handler())
+	} else {
+		go func() {
+			err := http.ListenAndServe(metricsAddr, metricsMux())
+			if err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "Unable to start metrics server: %s\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	s := &http.Server{
 		Addr:    addr,
 		Handler: mux,
@@ -131,12 +247,29 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	go worker(hookChan, t)
+	spoolStop := make(chan struct{})
+	var worker sync.WaitGroup
+	worker.Add(1)
+	if spool != nil {
+		go func() {
+			defer worker.Done()
+			spool.Run(disp, spoolStop)
+		}()
+	} else {
+		go func() {
+			defer worker.Done()
+			disp.run(hookChan)
+		}()
+	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 	<-sigCh
 
+	// Stop accepting new webhooks before waiting for in-flight ones, so a
+	// handler can never start sending on hookChan after it's closed below.
+	atomic.StoreInt32(&shuttingDown, 1)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -145,47 +278,20 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	for len(hookChan) > 0 {
-		time.Sleep(1)
-	}
-	close(hookChan)
-
-	return nil
-}
+	inFlight.Wait()
 
-func worker(hookChan chan *notify.WebhookMessage, t *template.Template) {
-	for wh := range hookChan {
-		for _, alert := range wh.Alerts {
-			var buf bytes.Buffer
-			var msg string
-			err := t.Execute(&buf, alert)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Invalid template: %s\n", err)
-				if alert.Labels["alertname"] == "" {
-					msg = "fallback"
-				} else {
-					msg = alert.Labels["alertname"]
-				}
-			} else {
-				msg = buf.String()
-			}
-			packet := &raven.Packet{
-				Timestamp: raven.Timestamp(time.Now()),
-				Message:   msg,
-				Extra: map[string]interface{}{
-					"firing_since": raven.Timestamp(alert.StartsAt),
-					"firing_until": raven.Timestamp(alert.EndsAt)},
-				Logger:      "alertmanager",
-				Fingerprint: []string{alert.Labels["alertname"], alert.Labels["namespace"], alert.Labels["pod_name"]},
-				ServerName:  fmt.Sprintf("%s/%s", alert.Labels["namespace"], alert.Labels["pod_name"]),
-			}
+	if spool != nil {
+		close(spoolStop)
+	} else {
+		close(hookChan)
+	}
 
-			eventID, ch := raven.Capture(packet, alert.Labels)
-			<-ch
+	// Wait for the worker to finish delivering whatever it already pulled
+	// off the channel/spool before the process exits, so a shutdown can
+	// never cut off the final in-flight alert mid-delivery.
+	worker.Wait()
 
-			log.Printf("event_id:%s alert_name:%s\n", eventID, alert.Labels["alertname"])
-		}
-	}
+	return nil
 }
 
 func version() {
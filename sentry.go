@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/alertmanager/notify"
+	amt "github.com/prometheus/alertmanager/template"
+)
+
+// sendTimeout bounds how long a single alert waits for Sentry's transport to
+// flush before the send is counted as a failure.
+const sendTimeout = 5 * time.Second
+
+// sentryAPIClient is used for the optional Sentry Web API issue-close calls,
+// kept separate from the ingestion hubs built by buildHubPool.
+var sentryAPIClient = &http.Client{Timeout: 10 * time.Second}
+
+// buildHubPool constructs one *sentry.Hub per distinct DSN referenced by cfg
+// (the default plus every route), built once at startup so the sentrySink
+// never has to create a client per event.
+func buildHubPool(cfg *Config) (map[string]*sentry.Hub, error) {
+	hubs := make(map[string]*sentry.Hub)
+
+	dsns := []string{cfg.DefaultDSN}
+	for _, route := range cfg.Routes {
+		dsns = append(dsns, route.DSN)
+	}
+
+	for _, dsn := range dsns {
+		if _, ok := hubs[dsn]; ok {
+			continue
+		}
+
+		client, err := sentry.NewClient(sentry.ClientOptions{Dsn: dsn})
+		if err != nil {
+			return nil, fmt.Errorf("building Sentry client for dsn %q: %w", dsn, err)
+		}
+		hubs[dsn] = sentry.NewHub(client, sentry.NewScope())
+	}
+
+	return hubs, nil
+}
+
+// eventHelper wraps a sentry.Event so that it can be mutated from the
+// message template itself, e.g. `{{ .Event.SetTag "cluster" .Labels.cluster }}`.
+// Methods return "" so they render as nothing when used inside a template action.
+type eventHelper struct {
+	*sentry.Event
+}
+
+func (e *eventHelper) SetTag(key, value string) string {
+	if e.Tags == nil {
+		e.Tags = map[string]string{}
+	}
+	e.Tags[key] = value
+	return ""
+}
+
+// templateData is the value the Sentry sink's message template executes
+// against. It embeds amt.Alert so existing templates (e.g.
+// `.Labels.alertname`) keep working unchanged, and exposes Event so
+// templates can shape the outgoing Sentry event without recompiling the
+// gateway.
+type templateData struct {
+	amt.Alert
+	Event *eventHelper
+}
+
+// severityLevel maps an alert's `severity` label to a Sentry level.
+func severityLevel(severity string) sentry.Level {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return sentry.LevelFatal
+	case "warning":
+		return sentry.LevelWarning
+	case "info":
+		return sentry.LevelInfo
+	default:
+		return sentry.LevelError
+	}
+}
+
+// sentrySink is the gateway's original, richer sink: it builds a structured
+// sentry.Event per alert (tags, contexts, fingerprint, level) rather than
+// just posting rendered text. It implements eventSink in addition to Sink so
+// the dispatcher can give its template access to the in-progress event.
+type sentrySink struct {
+	cfg  *Config
+	hubs map[string]*sentry.Hub
+}
+
+func newSentrySink(cfg *Config, hubs map[string]*sentry.Hub) *sentrySink {
+	return &sentrySink{cfg: cfg, hubs: hubs}
+}
+
+func (s *sentrySink) Name() string { return "sentry" }
+
+// Send satisfies the plain Sink interface for callers that only have
+// rendered text (it builds a minimal event around it). Dispatch normally
+// prefers SendEvent below, which lets the template shape the event directly.
+func (s *sentrySink) Send(ctx context.Context, alert amt.Alert, rendered string) error {
+	event := sentry.NewEvent()
+	event.Message = rendered
+	return s.send(alert, nil, event)
+}
+
+// SendEvent builds the structured event for alert (tags from wh's group and
+// common labels, annotation promotion, fingerprint, severity), executes tmpl
+// against it so the template can mutate it via .Event, and sends the result.
+func (s *sentrySink) SendEvent(wh *notify.WebhookMessage, alert amt.Alert, tmpl *template.Template) error {
+	event := sentry.NewEvent()
+	event.Timestamp = time.Now()
+	event.Logger = "alertmanager"
+	event.Level = severityLevel(alert.Labels["severity"])
+	event.Fingerprint = []string{alert.Labels["alertname"], alert.Labels["namespace"], alert.Labels["pod_name"]}
+	event.ServerName = fmt.Sprintf("%s/%s", alert.Labels["namespace"], alert.Labels["pod_name"])
+	event.Extra = map[string]interface{}{
+		"firing_since": alert.StartsAt,
+		"firing_until": alert.EndsAt,
+	}
+	event.Contexts["generator"] = map[string]interface{}{"url": alert.GeneratorURL}
+
+	event.Tags = map[string]string{}
+	for k, v := range wh.GroupLabels {
+		event.Tags[k] = v
+	}
+	for k, v := range wh.CommonLabels {
+		event.Tags[k] = v
+	}
+	// Tag with the same fingerprint used for dedup so CloseIssue can find
+	// this issue again by it when the alert resolves.
+	event.Tags["fingerprint"] = alertFingerprint(alert.Labels)
+
+	if alert.Status == "resolved" {
+		event.Level = sentry.LevelInfo
+		event.Tags["resolved"] = "true"
+	}
+
+	annotationTags := make(map[string]bool, len(s.cfg.TagAnnotations))
+	for _, k := range s.cfg.TagAnnotations {
+		annotationTags[k] = true
+	}
+	annotationCtx := map[string]interface{}{}
+	for k, v := range alert.Annotations {
+		if annotationTags[k] {
+			event.Tags[k] = v
+		} else {
+			annotationCtx[k] = v
+		}
+	}
+	if len(annotationCtx) > 0 {
+		event.Contexts["annotations"] = annotationCtx
+	}
+
+	data := templateData{Alert: alert, Event: &eventHelper{event}}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid template: %s\n", err)
+		templateRenderFailures.Inc()
+		if alert.Labels["alertname"] == "" {
+			event.Message = "fallback"
+		} else {
+			event.Message = alert.Labels["alertname"]
+		}
+	} else {
+		event.Message = buf.String()
+	}
+
+	return s.send(alert, wh, event)
+}
+
+func (s *sentrySink) send(alert amt.Alert, wh *notify.WebhookMessage, event *sentry.Event) error {
+	hub := s.hubs[s.cfg.dsnFor(alert.Labels)]
+
+	start := time.Now()
+	eventID := hub.CaptureEvent(event)
+	sent := eventID != nil && hub.Client().Flush(sendTimeout)
+	sentrySendDuration.Observe(time.Since(start).Seconds())
+
+	if !sent {
+		return fmt.Errorf("sentry did not accept event for alert %s", alert.Labels["alertname"])
+	}
+
+	log.Printf("event_id:%s alert_name:%s\n", *eventID, alert.Labels["alertname"])
+	return nil
+}
+
+// CloseIssue resolves the Sentry issue carrying the given fingerprint tag
+// via the Sentry Web API, using whichever SentryAPI labels routes to (the
+// same route-then-default precedence as dsnFor, so a multi-tenant config
+// closes the issue in the project the alert was actually sent to). It is a
+// no-op (returning nil) when that resolves to no SentryAPI, since closing
+// issues on resolve is an optional extra on top of the dedup-window/
+// resolved-tag handling above.
+func (s *sentrySink) CloseIssue(fingerprint string, labels map[string]string) error {
+	api := s.cfg.sentryAPIFor(labels)
+	if api == nil {
+		return nil
+	}
+
+	issueID, err := findIssueByFingerprint(api, fingerprint)
+	if err != nil {
+		return fmt.Errorf("looking up Sentry issue for fingerprint: %w", err)
+	}
+	if issueID == "" {
+		return nil
+	}
+
+	return setIssueStatus(api, issueID, "resolved")
+}
+
+// findIssueByFingerprint searches the configured Sentry project for an
+// unresolved issue tagged with fingerprint, returning its issue ID or "" if
+// none is found.
+func findIssueByFingerprint(api *SentryAPIConfig, fingerprint string) (string, error) {
+	u := fmt.Sprintf("%s/projects/%s/%s/issues/?query=%s",
+		strings.TrimRight(api.BaseURL, "/"), api.Org, api.Project,
+		url.QueryEscape("is:unresolved fingerprint:"+fingerprint))
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+api.AuthToken)
+
+	resp, err := sentryAPIClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("sentry API returned status %d", resp.StatusCode)
+	}
+
+	var issues []struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&issues); err != nil {
+		return "", err
+	}
+	if len(issues) == 0 {
+		return "", nil
+	}
+
+	return issues[0].ID, nil
+}
+
+// setIssueStatus updates an issue's status (e.g. "resolved") via the Sentry
+// Web API.
+func setIssueStatus(api *SentryAPIConfig, issueID, status string) error {
+	body, err := json.Marshal(map[string]string{"status": status})
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/issues/%s/", strings.TrimRight(api.BaseURL, "/"), issueID)
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+api.AuthToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sentryAPIClient.Do(req)
+	if err != nil {
+		sentryIssuesClosedTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		sentryIssuesClosedTotal.WithLabelValues("failure").Inc()
+		return fmt.Errorf("sentry API returned status %d", resp.StatusCode)
+	}
+
+	sentryIssuesClosedTotal.WithLabelValues("success").Inc()
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	amt "github.com/prometheus/alertmanager/template"
+)
+
+// Sink is an outbound notification destination. Rendering happens once per
+// alert against the sink's own template; Send delivers the result.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, alert amt.Alert, rendered string) error
+}
+
+// routedSink pairs a Sink with the label selector and compiled template that
+// determine which alerts it receives and how they're rendered.
+type routedSink struct {
+	sink  Sink
+	match map[string]string
+	tmpl  *template.Template
+}
+
+func (r routedSink) matches(labels map[string]string) bool {
+	return labelsMatch(r.match, labels)
+}
+
+// buildRoutedSinks constructs a routedSink for every configured Sink plus any
+// extras (e.g. the Sentry sink, which is wired up separately because it
+// shapes a structured event rather than a plain rendered string). Each
+// sink's own Template overrides defaultTmpl when set.
+func buildRoutedSinks(cfg *Config, defaultTmpl *template.Template, extras ...routedSink) ([]routedSink, error) {
+	sinks := append([]routedSink{}, extras...)
+
+	for _, sc := range cfg.Sinks {
+		sink, err := buildSink(sc)
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: %w", sc.Name, err)
+		}
+
+		t := defaultTmpl
+		if sc.Template != "" {
+			t, err = parseTemplateFile(sc.Template)
+			if err != nil {
+				return nil, fmt.Errorf("sink %q: %w", sc.Name, err)
+			}
+		}
+
+		sinks = append(sinks, routedSink{sink: sink, match: sc.Match, tmpl: t})
+	}
+
+	return sinks, nil
+}
+
+// parseTemplateFile compiles a message template the same way the gateway's
+// --template flag does.
+func parseTemplateFile(path string) (*template.Template, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading template: %w", err)
+	}
+
+	t := template.New("").Option("missingkey=zero")
+	t.Funcs(template.FuncMap(amt.DefaultFuncs))
+	return t.Parse(string(file))
+}
+
+// buildSink constructs the Sink for a SinkConfig entry.
+func buildSink(sc SinkConfig) (Sink, error) {
+	name := sc.Name
+	if name == "" {
+		name = sc.Type
+	}
+
+	switch sc.Type {
+	case "slack":
+		return newWebhookSink(name, sc.URL, func(rendered string) interface{} {
+			return map[string]string{"text": rendered}
+		}), nil
+	case "discord":
+		return newWebhookSink(name, sc.URL, func(rendered string) interface{} {
+			return map[string]string{"content": rendered}
+		}), nil
+	case "dingtalk":
+		return newWebhookSink(name, sc.URL, func(rendered string) interface{} {
+			return map[string]interface{}{
+				"msgtype": "text",
+				"text":    map[string]string{"content": rendered},
+			}
+		}), nil
+	case "feishu":
+		return newWebhookSink(name, sc.URL, func(rendered string) interface{} {
+			return map[string]interface{}{
+				"msg_type": "text",
+				"content":  map[string]string{"text": rendered},
+			}
+		}), nil
+	case "wecom":
+		return newWebhookSink(name, sc.URL, func(rendered string) interface{} {
+			return map[string]interface{}{
+				"msgtype": "text",
+				"text":    map[string]string{"content": rendered},
+			}
+		}), nil
+	case "webhook":
+		return newWebhookSink(name, sc.URL, func(rendered string) interface{} {
+			return map[string]string{"text": rendered}
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+	}
+}
+
+// webhookSink posts the rendered alert text to an HTTP endpoint, wrapped in
+// envelope's destination-specific JSON body.
+type webhookSink struct {
+	name     string
+	url      string
+	envelope func(rendered string) interface{}
+	client   *http.Client
+}
+
+func newWebhookSink(name, url string, envelope func(string) interface{}) *webhookSink {
+	return &webhookSink{
+		name:     name,
+		url:      url,
+		envelope: envelope,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *webhookSink) Name() string { return s.name }
+
+func (s *webhookSink) Send(ctx context.Context, alert amt.Alert, rendered string) error {
+	body, err := json.Marshal(s.envelope(rendered))
+	if err != nil {
+		return fmt.Errorf("encoding %s payload: %w", s.name, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to %s: %w", s.name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", s.name, resp.StatusCode)
+	}
+
+	return nil
+}
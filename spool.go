@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+)
+
+const (
+	spoolMinBackoff = time.Second
+	spoolMaxBackoff = 5 * time.Minute
+)
+
+// Spool persists incoming WebhookMessage payloads to disk so that a Sentry
+// outage or gateway restart cannot lose alerts that have already been
+// accepted over HTTP. Files are named by arrival order so the oldest is
+// always sent first, and the spool evicts its own oldest entries once
+// maxFiles or maxSizeBytes is exceeded.
+type Spool struct {
+	dir          string
+	maxFiles     int
+	maxSizeBytes int64
+}
+
+// NewSpool creates (if necessary) dir and returns a Spool bounded by maxFiles
+// and maxSizeMB.
+func NewSpool(dir string, maxFiles int, maxSizeMB int) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating spool dir %q: %w", dir, err)
+	}
+
+	return &Spool{
+		dir:          dir,
+		maxFiles:     maxFiles,
+		maxSizeBytes: int64(maxSizeMB) * 1024 * 1024,
+	}, nil
+}
+
+// spoolEntry is the on-disk shape of a spool file. Webhook.Alerts holds only
+// the alerts still owed a delivery, and PendingSinks records, per alert
+// fingerprint, exactly which sinks still need to accept it. A freshly
+// enqueued webhook has a nil PendingSinks, meaning every matching sink is
+// still owed a delivery.
+type spoolEntry struct {
+	Webhook      notify.WebhookMessage `json:"webhook"`
+	PendingSinks map[string][]string   `json:"pending_sinks,omitempty"`
+}
+
+// Enqueue writes wh to disk and evicts the oldest spooled files if doing so
+// pushed the spool over its file-count or size quota.
+func (s *Spool) Enqueue(wh *notify.WebhookMessage) error {
+	body, err := json.Marshal(spoolEntry{Webhook: *wh})
+	if err != nil {
+		return fmt.Errorf("marshalling webhook for spool: %w", err)
+	}
+
+	name := fmt.Sprintf("%020d.json", time.Now().UnixNano())
+	path := filepath.Join(s.dir, name)
+	if err := ioutil.WriteFile(path, body, 0o644); err != nil {
+		return fmt.Errorf("writing spool file: %w", err)
+	}
+
+	return s.evict()
+}
+
+// entries returns the spooled file names, oldest first. The zero-padded
+// nanosecond-timestamp naming scheme means lexical sort is chronological.
+func (s *Spool) entries() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// evict removes the oldest spooled files until the spool satisfies both
+// maxFiles and maxSizeBytes.
+func (s *Spool) evict() error {
+	names, err := s.entries()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	sizes := make([]int64, len(names))
+	for i, name := range names {
+		info, err := os.Stat(filepath.Join(s.dir, name))
+		if err != nil {
+			return err
+		}
+		sizes[i] = info.Size()
+		total += info.Size()
+	}
+
+	i := 0
+	for (s.maxFiles > 0 && len(names)-i > s.maxFiles) || (s.maxSizeBytes > 0 && total > s.maxSizeBytes) {
+		path := filepath.Join(s.dir, names[i])
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		spoolEvicted.Inc()
+		total -= sizes[i]
+		i++
+	}
+
+	return nil
+}
+
+// Run drains the spool in FIFO order, sending each webhook through disp. If
+// some (alert, sink) pairs fail to send, the file is rewritten to contain
+// only the alerts and sinks still pending and retried with exponential
+// backoff, so a sink that already accepted an alert is never handed it
+// again. It blocks until stop is closed.
+func (s *Spool) Run(disp *Dispatcher, stop <-chan struct{}) {
+	backoff := spoolMinBackoff
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		names, err := s.entries()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Spool read failed: %s\n", err)
+			sleepOrStop(spoolMinBackoff, stop)
+			continue
+		}
+
+		if len(names) == 0 {
+			backoff = spoolMinBackoff
+			if sleepOrStop(spoolMinBackoff, stop) {
+				return
+			}
+			continue
+		}
+
+		path := filepath.Join(s.dir, names[0])
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Spool file unreadable, discarding %s: %s\n", path, err)
+			os.Remove(path)
+			continue
+		}
+
+		var entry spoolEntry
+		if err := json.Unmarshal(body, &entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Spool file corrupt, discarding %s: %s\n", path, err)
+			os.Remove(path)
+			continue
+		}
+
+		total := len(entry.Webhook.Alerts)
+		failed := disp.processWebhook(&entry.Webhook, entry.PendingSinks)
+
+		if len(failed) > 0 {
+			next := spoolEntry{PendingSinks: make(map[string][]string, len(failed))}
+			next.Webhook.GroupLabels = entry.Webhook.GroupLabels
+			next.Webhook.CommonLabels = entry.Webhook.CommonLabels
+			for _, f := range failed {
+				next.Webhook.Alerts = append(next.Webhook.Alerts, f.Alert)
+				next.PendingSinks[alertFingerprint(f.Alert.Labels)] = f.PendingSinks
+			}
+
+			if body, err := json.Marshal(&next); err != nil {
+				fmt.Fprintf(os.Stderr, "Spool re-marshal failed, discarding %s: %s\n", path, err)
+				os.Remove(path)
+				continue
+			} else if err := ioutil.WriteFile(path, body, 0o644); err != nil {
+				fmt.Fprintf(os.Stderr, "Spool rewrite failed %s: %s\n", path, err)
+			}
+
+			fmt.Fprintf(os.Stderr, "Spool send failed for %d/%d alert(s), will retry %s in %s\n", len(failed), total, path, backoff)
+			if sleepOrStop(backoff, stop) {
+				return
+			}
+			backoff *= 2
+			if backoff > spoolMaxBackoff {
+				backoff = spoolMaxBackoff
+			}
+			continue
+		}
+
+		backoff = spoolMinBackoff
+		os.Remove(path)
+	}
+}
+
+// sleepOrStop sleeps for d, returning true early if stop is closed.
+func sleepOrStop(d time.Duration, stop <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-stop:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
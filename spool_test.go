@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	amt "github.com/prometheus/alertmanager/template"
+)
+
+func TestSpoolEvictByFileCount(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, 2, 0)
+	if err != nil {
+		t.Fatalf("NewSpool: %s", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%020d.json", i))
+		if err := ioutil.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatalf("writing fixture file: %s", err)
+		}
+	}
+
+	if err := s.evict(); err != nil {
+		t.Fatalf("evict: %s", err)
+	}
+
+	names, err := s.entries()
+	if err != nil {
+		t.Fatalf("entries: %s", err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("expected 2 remaining spool files, got %d: %v", len(names), names)
+	}
+	// evict removes the oldest first, so the two newest files should remain.
+	if names[0] != fmt.Sprintf("%020d.json", 2) || names[1] != fmt.Sprintf("%020d.json", 3) {
+		t.Fatalf("expected the two newest files to survive eviction, got %v", names)
+	}
+}
+
+func TestSpoolEvictBySize(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("NewSpool: %s", err)
+	}
+	s.maxSizeBytes = 10 // force eviction on size, not file count
+
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%020d.json", i))
+		if err := ioutil.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+			t.Fatalf("writing fixture file: %s", err)
+		}
+	}
+
+	if err := s.evict(); err != nil {
+		t.Fatalf("evict: %s", err)
+	}
+
+	names, err := s.entries()
+	if err != nil {
+		t.Fatalf("entries: %s", err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("expected eviction down to 1 file (10 bytes each, 10 byte quota), got %d: %v", len(names), names)
+	}
+}
+
+func TestSpoolEnqueueThenEntries(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("NewSpool: %s", err)
+	}
+
+	alert := amt.Alert{Labels: map[string]string{"alertname": "TestAlert"}, Status: "firing"}
+	if err := s.Enqueue(newTestWebhook(alert)); err != nil {
+		t.Fatalf("Enqueue: %s", err)
+	}
+
+	names, err := s.entries()
+	if err != nil {
+		t.Fatalf("entries: %s", err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("expected 1 spooled file after Enqueue, got %d", len(names))
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, names[0])); err != nil {
+		t.Fatalf("spooled file missing: %s", err)
+	}
+}